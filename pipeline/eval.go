@@ -0,0 +1,61 @@
+package pipeline
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"strings"
+
+	"github.com/fzzy/radix/redis"
+
+	"github.com/mediocregopher/radix-extra/pool"
+)
+
+// EvalScript wraps a Lua script so that it can be run via EVALSHA, with a
+// transparent fallback to EVAL (which also (re-)loads it into redis' script
+// cache) on a NOSCRIPT error. This means the same EvalScript keeps working
+// against a pool whose connections have never seen the script before, or
+// after a SCRIPT FLUSH, without the caller having to care either way.
+type EvalScript struct {
+	script string
+	sha    string
+}
+
+// NewEvalScript creates an EvalScript from the given Lua source. The sha1 used
+// for EVALSHA is computed once, up front, and reused for every Run.
+func NewEvalScript(script string) *EvalScript {
+	sum := sha1.Sum([]byte(script))
+	return &EvalScript{
+		script: script,
+		sha:    hex.EncodeToString(sum[:]),
+	}
+}
+
+// Run calls the script against a connection from pl, passing keys and args as
+// EVAL/EVALSHA's KEYS and ARGV respectively. The connection is acquired once
+// and reused for both the EVALSHA attempt and, if needed, the EVAL fallback,
+// so a routine NOSCRIPT doesn't churn through a perfectly healthy connection.
+func (s *EvalScript) Run(ctx context.Context, pl *pool.Pool, keys, args []interface{}) *redis.Reply {
+	evalArgs := make([]interface{}, 0, len(keys)+len(args)+2)
+	evalArgs = append(evalArgs, s.sha, len(keys))
+	evalArgs = append(evalArgs, keys...)
+	evalArgs = append(evalArgs, args...)
+
+	conn, err := pl.Get(ctx)
+	if err != nil {
+		return &redis.Reply{Type: redis.ErrorReply, Err: err}
+	}
+
+	r := conn.Cmd("EVALSHA", evalArgs...)
+	if r.Err != nil && strings.HasPrefix(r.Err.Error(), "NOSCRIPT") {
+		evalArgs[0] = s.script
+		r = conn.Cmd("EVAL", evalArgs...)
+	}
+
+	if r.Err != nil {
+		pl.Discard(conn)
+	} else {
+		pl.Put(conn)
+	}
+	return r
+}
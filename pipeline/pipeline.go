@@ -0,0 +1,71 @@
+// The pipeline package provides helpers for batching multiple commands
+// through a pool.Pool as a single pipelined round trip, and for calling Lua
+// scripts via EVALSHA with a transparent fallback to EVAL.
+package pipeline
+
+import (
+	"context"
+
+	"github.com/fzzy/radix/redis"
+
+	"github.com/mediocregopher/radix-extra/pool"
+)
+
+type cmd struct {
+	name string
+	args []interface{}
+}
+
+// Pipeline accumulates commands to be run against redis as a single batch: one
+// write of every queued command followed by one read of all their replies,
+// instead of a network round trip per command.
+type Pipeline struct {
+	cmds []cmd
+}
+
+// New returns an empty Pipeline.
+func New() *Pipeline {
+	return &Pipeline{}
+}
+
+// Cmd queues a command to be run the next time the Pipeline is Flushed, and
+// returns the Pipeline so calls can be chained.
+func (p *Pipeline) Cmd(name string, args ...interface{}) *Pipeline {
+	p.cmds = append(p.cmds, cmd{name: name, args: args})
+	return p
+}
+
+// Flush acquires a connection from pl, writes every queued command to it in a
+// single batch, and reads back one reply per command, in the order the
+// commands were queued. The connection is returned to pl once all replies
+// have been read, or discarded if any of them was an error. The Pipeline is
+// emptied and may be reused for another batch once Flush returns.
+func (p *Pipeline) Flush(ctx context.Context, pl *pool.Pool) ([]*redis.Reply, error) {
+	cmds := p.cmds
+	p.cmds = nil
+
+	conn, err := pl.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, c := range cmds {
+		conn.Append(c.name, c.args...)
+	}
+
+	replies := make([]*redis.Reply, len(cmds))
+	anyErr := false
+	for i := range cmds {
+		replies[i] = conn.ReadReply()
+		if replies[i].Err != nil {
+			anyErr = true
+		}
+	}
+
+	if anyErr {
+		pl.Discard(conn)
+	} else {
+		pl.Put(conn)
+	}
+	return replies, nil
+}
@@ -1,71 +1,199 @@
 package pool
 
 import (
+	"context"
+
 	"github.com/fzzy/radix/redis"
 )
 
-// A simple connection pool. It will create a small pool of initial connections,
-// and if more connections are needed they will be created on demand. If a
-// connection is returned and the pool is full it will be closed.
+// HealthCheck is run against an idle connection before Get hands it out, to
+// catch a connection which has silently gone bad while sitting in the pool.
+// If it returns an error the connection is closed, its slot freed, and Get
+// moves on to another idle connection, or dials a new one if none is idle.
+type HealthCheck func(*redis.Client) error
+
+// PingHealthCheck is a HealthCheck which issues a PING and treats an error in
+// the reply as the connection being unhealthy.
+func PingHealthCheck(conn *redis.Client) error {
+	return conn.Cmd("PING").Err
+}
+
+// A connection pool which enforces a hard cap on the number of connections it
+// will ever have open at once (idle or checked out), and can optionally
+// health-check a connection before handing it out. If more connections are
+// needed than are idle, and the pool hasn't hit its cap, one is dialed on
+// demand; once the cap is hit, Get blocks until a connection is returned or
+// its context is done.
 type Pool struct {
 	network string
 	addr    string
-	pool    chan *redis.Client
+
+	healthCheck HealthCheck
+
+	idle chan *redis.Client
+	sem  chan struct{} // one token held per open connection, idle or not
 }
 
-// Creates a new Pool whose connections are all created using
-// redis.Dial(network, addr). The size indicates the maximum number of idle
-// connections to have waiting to be used at any given moment
+// NewPool creates a new Pool whose connections are all created using
+// redis.Dial(network, addr). size is both the number of idle connections
+// created up front and the hard maximum on how many connections the pool
+// will ever have open at once.
 func NewPool(network, addr string, size int) (*Pool, error) {
-	var err error
-	pool := make([]*redis.Client, size)
-	for i := range pool {
-		if pool[i], err = redis.Dial(network, addr); err != nil {
+	return NewPoolCustom(network, addr, size, size, nil)
+}
+
+// NewPoolCustom is like NewPool, but allows the number of idle connections
+// created up front (size) and the hard cap on total open connections
+// (maxSize) to differ, and takes an optional HealthCheck to run against an
+// idle connection before Get hands it out.
+func NewPoolCustom(network, addr string, size, maxSize int, healthCheck HealthCheck) (*Pool, error) {
+	if maxSize < size {
+		maxSize = size
+	}
+	p := &Pool{
+		network:     network,
+		addr:        addr,
+		healthCheck: healthCheck,
+		idle:        make(chan *redis.Client, maxSize),
+		sem:         make(chan struct{}, maxSize),
+	}
+	for i := 0; i < size; i++ {
+		p.sem <- struct{}{}
+		conn, err := redis.Dial(network, addr)
+		if err != nil {
+			<-p.sem
+			p.Empty()
 			return nil, err
 		}
+		p.idle <- conn
+	}
+	return p, nil
+}
+
+// NewOrEmptyPool is like NewPool, but if dialing any of the initial
+// connections fails it still returns a (now empty) Pool rather than an error.
+// This is useful for creating a pool for a host which sentinel reports as up
+// but which may not actually be reachable yet; connections are then dialed on
+// demand by Get as usual.
+func NewOrEmptyPool(network, addr string, size int) *Pool {
+	if p, err := NewPool(network, addr, size); err == nil {
+		return p
 	}
-	p := Pool{
+	return &Pool{
 		network: network,
 		addr:    addr,
-		pool:    make(chan *redis.Client, len(pool)),
+		idle:    make(chan *redis.Client, size),
+		sem:     make(chan struct{}, size),
 	}
-	return &p, nil
 }
 
-// Retrieves an available redis client. If there are none available it will
-// create a new one on the fly
-func (p *Pool) Get() (*redis.Client, error) {
-	select {
-	case conn := <-p.pool:
-		return conn, nil
-	default:
-		return redis.Dial(p.network, p.addr)
+// Get retrieves an available connection, health-checking it first if a
+// HealthCheck was configured and dialing a new one if none are idle and the
+// pool hasn't hit its cap. If the pool is at its cap, Get blocks until a
+// connection is returned via Put/Discard or ctx is done.
+func (p *Pool) Get(ctx context.Context) (*redis.Client, error) {
+	for {
+		// prefer an idle connection over dialing a new one whenever one's
+		// actually available; checked non-blockingly first so this can't
+		// lose a race against the dial branch below when both are ready.
+		select {
+		case conn := <-p.idle:
+			if conn, err, ok := p.healthChecked(conn); ok {
+				return conn, err
+			}
+			continue
+		default:
+		}
+
+		select {
+		case conn := <-p.idle:
+			if conn, err, ok := p.healthChecked(conn); ok {
+				return conn, err
+			}
+			continue
+
+		case p.sem <- struct{}{}:
+			conn, err := redis.Dial(p.network, p.addr)
+			if err != nil {
+				<-p.sem
+				return nil, err
+			}
+			return conn, nil
+
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// healthChecked runs the configured HealthCheck (if any) against conn before
+// it's handed out by Get. ok is false if conn failed the check and was
+// closed, meaning Get should loop around and try again for another.
+func (p *Pool) healthChecked(conn *redis.Client) (c *redis.Client, err error, ok bool) {
+	if p.healthCheck == nil {
+		return conn, nil, true
+	}
+	if err := p.healthCheck(conn); err == nil {
+		return conn, nil, true
 	}
+	conn.Close()
+	<-p.sem // this connection is gone, try again for another
+	return nil, nil, false
 }
 
-// Returns a client back to the pool. If the pool is full the client is closed
-// instead. If the client is already closed (due to connection failure or
-// what-have-you) it should not be put back in the pool. The pool will create
-// more connections as needed.
+// Put returns a connection back to the pool. If the client is already closed
+// (due to connection failure or what-have-you) use Discard instead, so that
+// its slot isn't leaked.
 func (p *Pool) Put(conn *redis.Client) {
 	select {
-	case p.pool <- conn:
+	case p.idle <- conn:
 	default:
+		// the pool's somehow over its cap already; shouldn't normally happen,
+		// but close it rather than leak the connection
 		conn.Close()
+		<-p.sem
+	}
+}
+
+// Discard closes conn and frees its slot in the pool. It should be used
+// instead of Put whenever the connection being returned has had a connection
+// error or is otherwise not fit for reuse.
+func (p *Pool) Discard(conn *redis.Client) {
+	conn.Close()
+	<-p.sem
+}
+
+// Cmd acquires a connection from the pool, runs cmd against it, and returns
+// it to the pool (or Discards it, if the command errored) before returning
+// the reply. This is the recommended way to use a Pool for the common case of
+// a single command; Get/Put only need to be used directly when multiple
+// commands must run against the same connection (e.g. MULTI/EXEC).
+func (p *Pool) Cmd(ctx context.Context, cmd string, args ...interface{}) *redis.Reply {
+	conn, err := p.Get(ctx)
+	if err != nil {
+		return &redis.Reply{Type: redis.ErrorReply, Err: err}
+	}
+	r := conn.Cmd(cmd, args...)
+	if r.Err != nil {
+		p.Discard(conn)
+	} else {
+		p.Put(conn)
 	}
+	return r
 }
 
-// Removes and calls Close() on all the connections currently in the pool.
-// Assuming there are no other connections waiting to be Put back this method
-// effectively closes and cleans up the pool.
+// Removes, closes, and frees the slot of every idle connection currently in
+// the pool. Connections which are currently checked out are unaffected, and
+// will free their own slots as they're Put or Discarded. Assuming there are
+// no connections checked out this effectively closes and cleans up the pool.
 func (p *Pool) Empty() {
-	var conn *redis.Client
 	for {
 		select {
-			case conn = <-p.pool:
-				conn.Close()
-			default:
-				return
+		case conn := <-p.idle:
+			conn.Close()
+			<-p.sem
+		default:
+			return
 		}
 	}
 }
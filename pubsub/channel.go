@@ -0,0 +1,44 @@
+package pubsub
+
+import "context"
+
+// Channel returns a channel on which all subsequent SubReplys read by this
+// SubClient are delivered, and spawns a goroutine which loops on Receive to
+// feed it. bufferSize sets how many replies may be buffered before the
+// internal goroutine blocks delivering them. The channel is closed once
+// Receive returns a non-timeout error.
+//
+// Only one of Channel, ChannelWithContext, or Receive should be used on a
+// given SubClient; calling more than one of them means multiple goroutines
+// competing to read off of the same connection.
+func (c *SubClient) Channel(bufferSize int) <-chan *SubReply {
+	return c.ChannelWithContext(context.Background(), bufferSize)
+}
+
+// ChannelWithContext is like Channel, but the returned channel is also closed
+// (and the internal goroutine stopped) once ctx is cancelled. Cancellation is
+// only noticed between replies: a Receive already blocked waiting for the
+// next reply is not interrupted, so the channel closes after that reply.
+func (c *SubClient) ChannelWithContext(ctx context.Context, bufferSize int) <-chan *SubReply {
+	ch := make(chan *SubReply, bufferSize)
+	go func() {
+		defer close(ch)
+		for {
+			r := c.Receive()
+			select {
+			case ch <- r:
+			case <-ctx.Done():
+				return
+			}
+			if r.Err != nil && !r.Timeout() {
+				return
+			}
+			select {
+			case <-ctx.Done():
+				return
+			default:
+			}
+		}
+	}()
+	return ch
+}
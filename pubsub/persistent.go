@@ -0,0 +1,399 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/fzzy/radix/redis"
+)
+
+// DialFunc is used by PersistentSubClient to establish its connection to
+// redis, both initially and on every reconnect. It wraps redis.Dial so that
+// callers can run AUTH/SELECT or any other initialization on the connection
+// before it's used.
+type DialFunc func() (*redis.Client, error)
+
+// PersistentSubClient wraps a SubClient, tracking the set of channels and
+// patterns subscribed to and transparently reconnecting and resubscribing to
+// them whenever the underlying connection is lost, so that callers never have
+// to notice a dead connection and rebuild their own SubClient.
+//
+// As with SubClient, only one of Receive, Channel, or ChannelWithContext
+// should be used on a given PersistentSubClient at a time.
+type PersistentSubClient struct {
+	dial DialFunc
+
+	mu         sync.Mutex
+	sub        *SubClient
+	channels   map[string]struct{}
+	patterns   map[string]struct{}
+	lastRecv   time.Time      // last time anything, including a pong, was read
+	pendingAck chan *SubReply // set while a Subscribe/Unsubscribe/etc is awaiting its reply
+
+	pingInterval time.Duration
+	pingTimeout  time.Duration
+	closeCh      chan struct{}
+	closeOnce    sync.Once
+}
+
+// NewPersistentSubClient creates a PersistentSubClient which dials its
+// connections using redis.Dial(network, addr).
+func NewPersistentSubClient(network, addr string) (*PersistentSubClient, error) {
+	return NewPersistentSubClientCustom(func() (*redis.Client, error) {
+		return redis.Dial(network, addr)
+	})
+}
+
+// NewPersistentSubClientCustom is like NewPersistentSubClient, but takes a
+// DialFunc to use for every (re)connection instead of a bare network/address
+// pair, so AUTH/SELECT/etc... can be run as part of connecting.
+func NewPersistentSubClientCustom(dial DialFunc) (*PersistentSubClient, error) {
+	client, err := dial()
+	if err != nil {
+		return nil, err
+	}
+
+	p := &PersistentSubClient{
+		dial:         dial,
+		sub:          NewSubClient(client),
+		channels:     map[string]struct{}{},
+		patterns:     map[string]struct{}{},
+		lastRecv:     time.Now(),
+		pingInterval: 30 * time.Second,
+		pingTimeout:  10 * time.Second,
+		closeCh:      make(chan struct{}),
+	}
+	go p.pingSpin()
+	return p, nil
+}
+
+// SetPingInterval changes how often an otherwise-idle connection is pinged to
+// detect a silently-dead TCP connection, and how long a pong is waited for
+// before the connection is considered dead and reconnected. The default is a
+// 30 second interval with a 10 second timeout.
+func (p *PersistentSubClient) SetPingInterval(interval, timeout time.Duration) {
+	p.mu.Lock()
+	p.pingInterval = interval
+	p.pingTimeout = timeout
+	p.mu.Unlock()
+}
+
+// Subscribe makes a Redis "SUBSCRIBE" command on the provided channels, and
+// remembers them so they're automatically re-subscribed to on reconnect.
+func (p *PersistentSubClient) Subscribe(channels ...interface{}) *SubReply {
+	r := p.writeAndAwaitAck("SUBSCRIBE", channels)
+	if r.Err == nil {
+		p.mu.Lock()
+		for _, ch := range channels {
+			p.channels[fmt.Sprint(ch)] = struct{}{}
+		}
+		p.mu.Unlock()
+	}
+	return r
+}
+
+// PSubscribe makes a Redis "PSUBSCRIBE" command on the provided patterns, and
+// remembers them so they're automatically re-subscribed to on reconnect.
+func (p *PersistentSubClient) PSubscribe(patterns ...interface{}) *SubReply {
+	r := p.writeAndAwaitAck("PSUBSCRIBE", patterns)
+	if r.Err == nil {
+		p.mu.Lock()
+		for _, pat := range patterns {
+			p.patterns[fmt.Sprint(pat)] = struct{}{}
+		}
+		p.mu.Unlock()
+	}
+	return r
+}
+
+// Unsubscribe makes a Redis "UNSUBSCRIBE" command on the provided channels,
+// and forgets them so they are not re-subscribed to on reconnect.
+func (p *PersistentSubClient) Unsubscribe(channels ...interface{}) *SubReply {
+	r := p.writeAndAwaitAck("UNSUBSCRIBE", channels)
+	if r.Err == nil {
+		p.mu.Lock()
+		for _, ch := range channels {
+			delete(p.channels, fmt.Sprint(ch))
+		}
+		p.mu.Unlock()
+	}
+	return r
+}
+
+// PUnsubscribe makes a Redis "PUNSUBSCRIBE" command on the provided patterns,
+// and forgets them so they are not re-subscribed to on reconnect.
+func (p *PersistentSubClient) PUnsubscribe(patterns ...interface{}) *SubReply {
+	r := p.writeAndAwaitAck("PUNSUBSCRIBE", patterns)
+	if r.Err == nil {
+		p.mu.Lock()
+		for _, pat := range patterns {
+			delete(p.patterns, fmt.Sprint(pat))
+		}
+		p.mu.Unlock()
+	}
+	return r
+}
+
+// writeAndAwaitAck writes cmd/args to the connection without reading back its
+// reply directly, then waits for whichever goroutine is looping on Receive to
+// hand that reply back over pendingAck. This avoids the same two-readers
+// problem Ping avoids (see its doc comment): issuing the SUBSCRIBE/
+// UNSUBSCRIBE/etc itself and reading its reply here would race an in-flight
+// Receive call on the same connection, which fzzy/radix doesn't support. Only
+// one such call may be outstanding at a time.
+func (p *PersistentSubClient) writeAndAwaitAck(cmd string, args []interface{}) *SubReply {
+	p.mu.Lock()
+	if p.pendingAck != nil {
+		p.mu.Unlock()
+		return &SubReply{Err: errors.New("pubsub: a Subscribe/Unsubscribe call is already in progress")}
+	}
+	ack := make(chan *SubReply, 1)
+	p.pendingAck = ack
+	err := p.sub.Client.Append(cmd, args...)
+	if err != nil {
+		p.pendingAck = nil
+	}
+	p.mu.Unlock()
+
+	if err != nil {
+		return &SubReply{Err: err}
+	}
+	return <-ack
+}
+
+// Receive returns the next publish reply, transparently reconnecting and
+// re-subscribing to the tracked channels/patterns if the connection has died
+// in the meantime. A pong delivered by the keepalive ping (see pingSpin) is
+// consumed here rather than returned, since it's an implementation detail
+// callers shouldn't have to filter out themselves. Likewise, whichever
+// goroutine is looping on Receive (here or in the Channel goroutine) is also
+// responsible for delivering the reply to an in-flight Subscribe/Unsubscribe/
+// etc call, via pendingAck, rather than returning it here.
+func (p *PersistentSubClient) Receive() *SubReply {
+	for {
+		p.mu.Lock()
+		sub := p.sub
+		p.mu.Unlock()
+
+		r := sub.Receive()
+
+		// only a (un)subscribe confirmation or an error can be the reply a
+		// Subscribe/Unsubscribe/etc call is waiting on
+		if r.Err != nil || r.Type == SubscribeType || r.Type == UnsubscribeType {
+			if ack := p.takePendingAck(); ack != nil {
+				ack <- r
+				if r.Err != nil && isDeadConnErr(r.Err) {
+					if err := p.reconnect(); err != nil {
+						return &SubReply{Type: ErrorReply, Err: err}
+					}
+				}
+				continue
+			}
+		}
+
+		if r.Err == nil {
+			p.mu.Lock()
+			p.lastRecv = time.Now()
+			p.mu.Unlock()
+			if r.Type == PongType {
+				continue
+			}
+			return r
+		}
+		if !isDeadConnErr(r.Err) {
+			return r
+		}
+		if err := p.reconnect(); err != nil {
+			return &SubReply{Type: ErrorReply, Err: err}
+		}
+	}
+}
+
+// takePendingAck returns and clears the channel a Subscribe/Unsubscribe/etc
+// call is waiting on for its reply, or nil if none is outstanding.
+func (p *PersistentSubClient) takePendingAck() chan *SubReply {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ack := p.pendingAck
+	p.pendingAck = nil
+	return ack
+}
+
+// isDeadConnErr returns true if err indicates the underlying connection is no
+// longer usable, as opposed to e.g. the reply simply not being a pub/sub
+// reply.
+func isDeadConnErr(err error) bool {
+	if err == nil {
+		return false
+	}
+	if err == io.EOF {
+		return true
+	}
+	_, ok := err.(net.Error)
+	return ok
+}
+
+// reconnect closes the current connection (if any), dials a new one, and
+// re-issues SUBSCRIBE/PSUBSCRIBE for every tracked channel and pattern. Once
+// Close has been called it dials nothing and returns an error instead.
+func (p *PersistentSubClient) reconnect() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	select {
+	case <-p.closeCh:
+		return errors.New("pubsub: client is closed")
+	default:
+	}
+
+	p.sub.Client.Close()
+
+	client, err := p.dial()
+	if err != nil {
+		return err
+	}
+	sub := NewSubClient(client)
+
+	if len(p.channels) > 0 {
+		channels := make([]interface{}, 0, len(p.channels))
+		for ch := range p.channels {
+			channels = append(channels, ch)
+		}
+		if r := sub.Subscribe(channels...); r.Err != nil {
+			client.Close()
+			return r.Err
+		}
+	}
+
+	if len(p.patterns) > 0 {
+		patterns := make([]interface{}, 0, len(p.patterns))
+		for pat := range p.patterns {
+			patterns = append(patterns, pat)
+		}
+		if r := sub.PSubscribe(patterns...); r.Err != nil {
+			client.Close()
+			return r.Err
+		}
+	}
+
+	p.sub = sub
+	p.lastRecv = time.Now()
+	return nil
+}
+
+// pingSpin periodically pings the connection to detect a silently-dead TCP
+// connection (one which was never closed but also isn't delivering any
+// traffic), forcing a reconnect if nothing is read back within the
+// configured timeout.
+//
+// The ping is only ever written here, never read: Receive (called from
+// whatever goroutine the caller uses) is the sole reader of the connection,
+// and sees the resulting pong as PongType. This avoids the race of two
+// goroutines both trying to read the same *redis.Client, which fzzy/radix
+// doesn't support. If the write itself fails, or nothing at all is read
+// within the timeout, the connection is forced closed so that Receive's
+// blocked read returns an error and takes the normal reconnect path.
+func (p *PersistentSubClient) pingSpin() {
+	for {
+		p.mu.Lock()
+		interval := p.pingInterval
+		p.mu.Unlock()
+
+		select {
+		case <-time.After(interval):
+		case <-p.closeCh:
+			return
+		}
+
+		p.mu.Lock()
+		sub := p.sub
+		timeout := p.pingTimeout
+		p.mu.Unlock()
+
+		sentAt := time.Now()
+		if err := sub.Ping(); err != nil {
+			p.forceClose(sub)
+			continue
+		}
+
+		select {
+		case <-time.After(timeout):
+		case <-p.closeCh:
+			return
+		}
+
+		p.mu.Lock()
+		stale := p.sub == sub && p.lastRecv.Before(sentAt)
+		p.mu.Unlock()
+		if stale {
+			p.forceClose(sub)
+		}
+	}
+}
+
+// forceClose closes sub's connection, but only if it's still the one
+// currently in use; this avoids racing with a reconnect that may have
+// already replaced it for an unrelated reason by the time pingSpin decides
+// the old one looks dead.
+func (p *PersistentSubClient) forceClose(sub *SubClient) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.sub == sub {
+		sub.Client.Close()
+	}
+}
+
+// Close closes the underlying connection and stops the background ping
+// goroutine. It should be called once no more Receive calls will be made.
+func (p *PersistentSubClient) Close() {
+	p.closeOnce.Do(func() { close(p.closeCh) })
+	p.mu.Lock()
+	p.sub.Client.Close()
+	p.mu.Unlock()
+}
+
+// Channel returns a channel on which all subsequent SubReplys read by this
+// PersistentSubClient are delivered, and spawns a goroutine which loops on
+// Receive to feed it. bufferSize sets how many replies may be buffered
+// before the internal goroutine blocks delivering them. The channel is
+// closed once Receive returns a non-timeout error, which for a
+// PersistentSubClient means even its own reconnect attempt failed.
+//
+// As with Receive, only one of Receive, Channel, or ChannelWithContext
+// should be used on a given PersistentSubClient at a time.
+func (p *PersistentSubClient) Channel(bufferSize int) <-chan *SubReply {
+	return p.ChannelWithContext(context.Background(), bufferSize)
+}
+
+// ChannelWithContext is like Channel, but the returned channel is also closed
+// (and the internal goroutine stopped) once ctx is cancelled. Cancellation is
+// only noticed between replies: a Receive already blocked waiting for the
+// next reply is not interrupted, so the channel closes after that reply.
+func (p *PersistentSubClient) ChannelWithContext(ctx context.Context, bufferSize int) <-chan *SubReply {
+	ch := make(chan *SubReply, bufferSize)
+	go func() {
+		defer close(ch)
+		for {
+			r := p.Receive()
+			select {
+			case ch <- r:
+			case <-ctx.Done():
+				return
+			}
+			if r.Err != nil && !r.Timeout() {
+				return
+			}
+			select {
+			case <-ctx.Done():
+				return
+			default:
+			}
+		}
+	}()
+	return ch
+}
@@ -2,6 +2,7 @@ package pubsub
 
 import (
 	"errors"
+	"net"
 
 	"github.com/fzzy/radix/redis"
 )
@@ -13,6 +14,7 @@ const (
 	SubscribeType
 	UnsubscribeType
 	MessageType
+	PongType
 )
 
 // SubClient wraps a Redis client to provide convenience methods for Pub/Sub functionality.
@@ -20,6 +22,13 @@ type SubClient struct {
 	Client *redis.Client
 }
 
+// NewSubClient takes an existing redis.Client and wraps it to provide the
+// Pub/Sub convenience methods. The passed in client should not be used for
+// anything else after this call is made.
+func NewSubClient(client *redis.Client) *SubClient {
+	return &SubClient{Client: client}
+}
+
 // SubReply wraps a Redis reply and provides convienient access to Pub/Sub info.
 type SubReply struct {
 	Type     SubReplyType // SubReply type
@@ -60,14 +69,43 @@ func (c *SubClient) Receive() *SubReply {
 	return c.parseReply(r)
 }
 
+// Ping writes a Redis "PING" to the connection without reading back its
+// reply. This lets a keepalive check a connection's liveness without
+// competing with whatever goroutine is already blocked in Receive waiting
+// for the next reply; the pong comes back through that same Receive call,
+// as PongType, instead.
+func (c *SubClient) Ping() error {
+	return c.Client.Append("PING")
+}
+
+// Timeout returns true if the SubReply's error is a net.Error indicating a
+// timeout, as opposed to some other kind of connection failure.
+func (sr *SubReply) Timeout() bool {
+	if nerr, ok := sr.Err.(net.Error); ok {
+		return nerr.Timeout()
+	}
+	return false
+}
+
 func (c *SubClient) parseReply(reply *redis.Reply) *SubReply {
 	sr := &SubReply{Reply: reply}
 	switch reply.Type {
 	case redis.MultiReply:
-		if len(reply.Elems) < 3 {
+		// PING, while subscribed, comes back as a 2-element pseudo-message
+		// (["pong", ""]) rather than the usual 3-element subscribe/
+		// unsubscribe/message format.
+		if len(reply.Elems) < 2 {
 			sr.Err = errors.New("reply is not formatted as a subscription reply")
 			return sr
 		}
+	case redis.StatusReply:
+		// outside of subscribed mode PING gets a plain +PONG status reply
+		if s, err := reply.Str(); err == nil && s == "PONG" {
+			sr.Type = PongType
+			return sr
+		}
+		sr.Err = errors.New("reply is not formatted as a subscription reply")
+		return sr
 	case redis.ErrorReply:
 		sr.Err = reply.Err
 		return sr
@@ -114,6 +152,8 @@ func (c *SubClient) parseReply(reply *redis.Reply) *SubReply {
 		} else {
 			sr.Message = msg
 		}
+	case "pong":
+		sr.Type = PongType
 	default:
 		sr.Err = errors.New("suscription multireply has invalid type: " + rtype)
 	}
@@ -6,7 +6,8 @@
 // called. It is still possible that there is a failover as that connection is
 // being used by the application, or before the PutMaster. Because of this,
 // always check errors and never PutMaster on a connection which has returned an
-// error.
+// error. The same gaurantee, and the same caveat, applies to GetSlave/PutSlave
+// and the replicas of a master.
 //
 // As a final note, a Client can be interacted with from multiple routines at
 // once safely, except for the Close method. To safely Close, ensure that only
@@ -15,14 +16,29 @@
 package sentinel
 
 import (
+	"context"
 	"errors"
-	"github.com/fzzy/radix/redis"
 	"strings"
+	"sync"
+	"time"
+
+	"github.com/fzzy/radix/redis"
 
+	"github.com/mediocregopher/radix-extra/pipeline"
 	"github.com/mediocregopher/radix-extra/pool"
 	"github.com/mediocregopher/radix-extra/pubsub"
 )
 
+// sentinelSentinelsInterval is how often SENTINEL SENTINELS is polled for
+// each tracked master, to learn about sentinels that weren't part of the
+// address list the Client was created with.
+const sentinelSentinelsInterval = 30 * time.Second
+
+// subChannelBufSize is the buffer size used for the channel that delivers
+// the sentinel pub/sub connection's replies to spin, via
+// PersistentSubClient.Channel.
+const subChannelBufSize = 64
+
 // An error wrapper returned by operations in this package. It implements the
 // error interface and can therefore be passed around as a normal error.
 type ClientError struct {
@@ -39,183 +55,783 @@ func (ce *ClientError) Error() string {
 	return ce.err.Error()
 }
 
-type getReqRet struct {
-	conn *redis.Client
-	err  *ClientError
+type putReq struct {
+	name    string
+	conn    *redis.Client
+	discard bool
+}
+
+// slaveInfo is a replica address as reported by SENTINEL SLAVES, along with
+// whether it was already flagged down at the time it was reported.
+type slaveInfo struct {
+	addr string
+	down bool
 }
 
-type getReq struct {
+// slavePoolEntry tracks a single known replica of a master. pool is nil while
+// the replica is flagged down (s_down/o_down), so that GetSlave never hands
+// out connections to a replica sentinel doesn't consider healthy.
+type slavePoolEntry struct {
+	addr string
+	pool *pool.Pool
+}
+
+// slaveEvent is a parsed +slave/+sdown/+odown/-sdown/-odown pub/sub message
+// about a single replica of one of the tracked masters.
+type slaveEvent struct {
+	kind       string // the pub/sub channel the event came in on
+	masterName string
+	addr       string
+}
+
+// sentinelConn bundles everything that comes from successfully connecting to
+// a single sentinel: the command connection used for SENTINEL MASTER/SLAVES/
+// SENTINELS calls, the pub/sub connection subscribed to the events this
+// package cares about, and the masters/slaves this client tracks as seen by
+// that sentinel.
+type sentinelConn struct {
+	cmdConn    *redis.Client
+	subClient  *pubsub.PersistentSubClient
+	subReplyCh <-chan *pubsub.SubReply // subClient's replies, via Channel
+	masters    map[string]string
+	slaves     map[string][]slaveInfo
+	addrs      []string // known sentinel addresses, the connected one first
+}
+
+type poolReq struct {
 	name  string
-	retCh chan *getReqRet
+	retCh chan *poolReqRet
 }
 
-type putReq struct {
-	name string
-	conn *redis.Client
+type poolReqRet struct {
+	pool *pool.Pool
+	err  *ClientError
 }
 
-type switchMaster struct {
-	name string
-	addr string
+// connReg tells spin which *pool.Pool a connection handed out by GetMaster or
+// GetSlave actually came from, so a later Put/Discard can be routed back to
+// the right one even if the name->pool mapping has since moved on (e.g. a
+// +switch-master replacing masterPools[name] while the connection is still
+// checked out). It's sent after Get succeeds, from the caller's own
+// goroutine, since Get itself is no longer run on spin.
+type connReg struct {
+	conn *redis.Client
+	pool *pool.Pool
 }
 
 type Client struct {
 	poolSize    int
+	names       []string
 	masterPools map[string]*pool.Pool
-	subClient   *pubsub.SubClient
+	masterAddrs map[string]string
+
+	masterConnPool map[*redis.Client]*pool.Pool
+
+	slavePools    map[string][]*slavePoolEntry
+	slaveIdx      map[string]int
+	slaveConnPool map[*redis.Client]*pool.Pool
 
-	getCh   chan *getReq
-	putCh   chan *putReq
-	closeCh chan struct{}
+	evalMu      sync.Mutex
+	evalScripts map[string]*pipeline.EvalScript
 
-	alwaysErr      *ClientError
-	alwaysErrCh    chan *ClientError
-	switchMasterCh chan *switchMaster
+	cmdConn    *redis.Client
+	subClient  *pubsub.PersistentSubClient
+	subReplyCh <-chan *pubsub.SubReply // delivers subClient's replies to spin, via Channel
+	sentAddrs  []string
+
+	putCh           chan *putReq
+	putSlaveCh      chan *putReq
+	poolReqCh       chan *poolReq
+	slavePoolReqCh  chan *poolReq
+	masterConnRegCh chan *connReg
+	slaveConnRegCh  chan *connReg
+	closeCh         chan struct{}
+
+	sentinelsReqCh chan chan []string
+
+	alwaysErr     *ClientError
+	alwaysErrCh   chan *ClientError
+	reconnectedCh chan *sentinelConn
 }
 
-// Creates a sentinel client. Connects to the given sentinel instance, pulls the
-// information for the masters of the given names, and creates an intial pool of
+// NewClient creates a sentinel client. It will try the given sentinel
+// addresses in order until one successfully answers, pull the information for
+// the masters of the given names from it, and create an initial pool of
 // connections for each master. The client will automatically replace the pool
-// for any master should sentinel decide to fail the master over.
+// for any master should sentinel decide to fail it over, and will
+// automatically move on to another sentinel address (re-discovering the
+// masters' current addresses in the process) should the one it's connected to
+// become unreachable.
 func NewClient(
-	network, address string, poolSize int, names ...string,
+	addrs []string, poolSize int, names ...string,
 ) (
 	*Client, *ClientError,
 ) {
+	if len(addrs) == 0 {
+		return nil, &ClientError{err: errors.New("at least one sentinel address is required")}
+	}
 
-	// We use this to fetch initial details about masters before we upgrade it
-	// to a pubsub client
-	client, err := redis.Dial(network, address)
+	sc, err := dialSentinel(addrs, names)
 	if err != nil {
-		return nil, &ClientError{err: err}
+		return nil, &ClientError{err: err, SentinelErr: true}
 	}
 
 	masterPools := map[string]*pool.Pool{}
-	for _, name := range names {
-		r := client.Cmd("SENTINEL", "MASTER", name)
-		l, err := r.List()
-		if err != nil {
-			return nil, &ClientError{err: err, SentinelErr: true}
-		}
-		addr := l[3] + ":" + l[5]
-		pool, err := pool.NewPool("tcp", addr, poolSize)
+	for name, addr := range sc.masters {
+		p, err := pool.NewPool("tcp", addr, poolSize)
 		if err != nil {
 			return nil, &ClientError{err: err}
 		}
-		masterPools[name] = pool
-	}
-
-	subClient := pubsub.NewSubClient(client)
-	r := subClient.Subscribe("+switch-master")
-	if r.Err != nil {
-		return nil, &ClientError{err: r.Err, SentinelErr: true}
+		masterPools[name] = p
 	}
 
 	c := &Client{
-		poolSize:       poolSize,
-		masterPools:    masterPools,
-		subClient:      subClient,
-		getCh:          make(chan *getReq),
-		putCh:          make(chan *putReq),
-		closeCh:        make(chan struct{}),
-		alwaysErrCh:    make(chan *ClientError),
-		switchMasterCh: make(chan *switchMaster),
+		poolSize:        poolSize,
+		names:           names,
+		masterPools:     masterPools,
+		masterAddrs:     sc.masters,
+		masterConnPool:  map[*redis.Client]*pool.Pool{},
+		slavePools:      map[string][]*slavePoolEntry{},
+		slaveIdx:        map[string]int{},
+		slaveConnPool:   map[*redis.Client]*pool.Pool{},
+		evalScripts:     map[string]*pipeline.EvalScript{},
+		cmdConn:         sc.cmdConn,
+		subClient:       sc.subClient,
+		subReplyCh:      sc.subReplyCh,
+		sentAddrs:       sc.addrs,
+		putCh:           make(chan *putReq),
+		putSlaveCh:      make(chan *putReq),
+		poolReqCh:       make(chan *poolReq),
+		slavePoolReqCh:  make(chan *poolReq),
+		masterConnRegCh: make(chan *connReg),
+		slaveConnRegCh:  make(chan *connReg),
+		closeCh:         make(chan struct{}),
+		sentinelsReqCh:  make(chan chan []string),
+		alwaysErrCh:     make(chan *ClientError),
+		reconnectedCh:   make(chan *sentinelConn),
 	}
+	c.installSlaves(sc.slaves)
 
-	go c.subSpin()
 	go c.spin()
 	return c, nil
 }
 
-func (c *Client) subSpin() {
-	for {
-		r := c.subClient.Receive()
-		if r.Timeout() {
+// dialSentinel tries each address in turn, dialing a command connection,
+// fetching the current address of every tracked master from it, dialing a
+// second, persistent connection to subscribe on, and subscribing it to
+// +switch-master. The first address to succeed at all of that is returned at
+// the front of the addrs list, with the rest following in their original
+// order.
+func dialSentinel(addrs []string, names []string) (*sentinelConn, error) {
+	var lastErr error
+	for i, addr := range addrs {
+		cmdConn, err := redis.Dial("tcp", addr)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		masters := map[string]string{}
+		ok := true
+		for _, name := range names {
+			r := cmdConn.Cmd("SENTINEL", "MASTER", name)
+			l, err := r.List()
+			if err != nil {
+				lastErr = err
+				ok = false
+				break
+			}
+			f := sentinelFields(l)
+			masters[name] = f["ip"] + ":" + f["port"]
+		}
+		if !ok {
+			cmdConn.Close()
+			continue
+		}
+
+		// Replicas are a nice-to-have; a sentinel which can't currently
+		// answer SENTINEL SLAVES for a master just means GetSlave won't have
+		// anything to hand out for it yet, not that this sentinel is unusable.
+		slaves := map[string][]slaveInfo{}
+		for _, name := range names {
+			r := cmdConn.Cmd("SENTINEL", "SLAVES", name)
+			if r.Type != redis.MultiReply {
+				continue
+			}
+			var infos []slaveInfo
+			for _, e := range r.Elems {
+				l, err := e.List()
+				if err != nil {
+					continue
+				}
+				f := sentinelFields(l)
+				down := strings.Contains(f["flags"], "s_down") || strings.Contains(f["flags"], "o_down")
+				infos = append(infos, slaveInfo{addr: f["ip"] + ":" + f["port"], down: down})
+			}
+			slaves[name] = infos
+		}
+
+		subClient, err := pubsub.NewPersistentSubClientCustom(func() (*redis.Client, error) {
+			return redis.Dial("tcp", addr)
+		})
+		if err != nil {
+			cmdConn.Close()
+			lastErr = err
 			continue
 		}
+		// Subscribe's reply is handed back by whichever goroutine is reading
+		// the connection, so that reader has to be running first.
+		subReplyCh := subClient.Channel(subChannelBufSize)
+		r := subClient.Subscribe(
+			"+switch-master", "+slave", "+sdown", "+odown", "-sdown", "-odown",
+		)
 		if r.Err != nil {
+			cmdConn.Close()
+			subClient.Close()
+			lastErr = r.Err
+			continue
+		}
+
+		ordered := make([]string, 0, len(addrs))
+		ordered = append(ordered, addr)
+		ordered = append(ordered, addrs[:i]...)
+		ordered = append(ordered, addrs[i+1:]...)
+
+		return &sentinelConn{
+			cmdConn:    cmdConn,
+			subClient:  subClient,
+			subReplyCh: subReplyCh,
+			masters:    masters,
+			slaves:     slaves,
+			addrs:      ordered,
+		}, nil
+	}
+	if lastErr == nil {
+		lastErr = errors.New("no sentinel addresses given")
+	}
+	return nil, lastErr
+}
+
+// sentinelFields turns a flat SENTINEL ... reply (field1, value1, field2,
+// value2, ...) into a map for convenient lookup.
+func sentinelFields(l []string) map[string]string {
+	m := make(map[string]string, len(l)/2)
+	for i := 0; i+1 < len(l); i += 2 {
+		m[l[i]] = l[i+1]
+	}
+	return m
+}
+
+// handleSubReply applies a reply read off the sentinel pub/sub connection:
+// a +switch-master updates the affected master's pool in place, and a
+// +slave/+sdown/+odown/-sdown/-odown is parsed and handed to
+// applySlaveEvent. It must only be called from spin.
+func (c *Client) handleSubReply(r *pubsub.SubReply) {
+	// subscribe confirmations (e.g. after a resubscribe) carry no event
+	if r.Type != pubsub.MessageType {
+		return
+	}
+	switch r.Channel {
+	case "+switch-master":
+		sMsg := strings.Split(r.Message, " ")
+		name := sMsg[0]
+		newAddr := sMsg[3] + ":" + sMsg[4]
+		c.masterAddrs[name] = newAddr
+		if p, ok := c.masterPools[name]; ok {
+			p.Empty()
+			c.masterPools[name] = pool.NewOrEmptyPool("tcp", newAddr, c.poolSize)
+		}
+
+	case "+slave", "+sdown", "+odown", "-sdown", "-odown":
+		fields := strings.Split(r.Message, " ")
+		// "<type> <name> <ip> <port> @ <master-name> <master-ip> <master-port>"
+		if len(fields) < 6 || fields[0] != "slave" {
+			return
+		}
+		c.applySlaveEvent(&slaveEvent{
+			kind:       r.Channel,
+			masterName: fields[5],
+			addr:       fields[2] + ":" + fields[3],
+		})
+	}
+}
+
+// reconnectSpin is run in its own goroutine whenever the currently-connected
+// sentinel is lost. It retries dialSentinel, with a backoff, against the
+// known set of addresses until one succeeds, then hands the result back to
+// spin over reconnectedCh.
+func (c *Client) reconnectSpin(addrs []string, names []string) {
+	backoff := 500 * time.Millisecond
+	const maxBackoff = 10 * time.Second
+	for {
+		sc, err := dialSentinel(addrs, names)
+		if err == nil {
 			select {
-			case c.alwaysErrCh <- &ClientError{err: r.Err, SentinelErr: true}:
+			case c.reconnectedCh <- sc:
 			case <-c.closeCh:
+				sc.cmdConn.Close()
+				sc.subClient.Close()
 			}
 			return
 		}
-		sMsg := strings.Split(r.Message, " ")
-		name := sMsg[0]
-		newAddr := sMsg[3] + ":" + sMsg[4]
+
 		select {
-		case c.switchMasterCh <- &switchMaster{name, newAddr}:
+		case <-time.After(backoff):
 		case <-c.closeCh:
 			return
 		}
+		if backoff < maxBackoff {
+			backoff *= 2
+		}
 	}
 }
 
 func (c *Client) spin() {
+	sentinelsTick := time.NewTicker(sentinelSentinelsInterval)
+	defer sentinelsTick.Stop()
+
 	for {
 		select {
-		case req := <-c.getCh:
-			if c.alwaysErr != nil {
-				req.retCh <- &getReqRet{nil, c.alwaysErr}
-				continue
+		case reg := <-c.masterConnRegCh:
+			c.masterConnPool[reg.conn] = reg.pool
+
+		case req := <-c.putCh:
+			if p, ok := c.masterConnPool[req.conn]; ok {
+				delete(c.masterConnPool, req.conn)
+				if c.masterPools[req.name] != p {
+					// a failover replaced the pool this connection came from
+					// out from under it while it was checked out; p is
+					// orphaned (already Empty()'d, with nothing else ever
+					// referencing it again), so just close the raw
+					// connection instead of handing it back to a pool
+					// nothing will ever Get it out of again.
+					req.conn.Close()
+				} else if req.discard {
+					p.Discard(req.conn)
+				} else {
+					p.Put(req.conn)
+				}
 			}
-			pool, ok := c.masterPools[req.name]
-			if !ok {
-				err := errors.New("unknown name: " + req.name)
-				req.retCh <- &getReqRet{nil, &ClientError{err: err}}
+
+		case req := <-c.slavePoolReqCh:
+			if c.alwaysErr != nil {
+				req.retCh <- &poolReqRet{nil, c.alwaysErr}
 				continue
 			}
-			conn, err := pool.Get()
+			p, err := c.selectSlavePool(req.name)
 			if err != nil {
-				req.retCh <- &getReqRet{nil, &ClientError{err: err}}
+				req.retCh <- &poolReqRet{nil, &ClientError{err: err}}
 				continue
 			}
-			req.retCh <- &getReqRet{conn, nil}
+			req.retCh <- &poolReqRet{p, nil}
 
-		case req := <-c.putCh:
-			if pool, ok := c.masterPools[req.name]; ok {
-				pool.Put(req.conn)
+		case reg := <-c.slaveConnRegCh:
+			c.slaveConnPool[reg.conn] = reg.pool
+
+		case req := <-c.putSlaveCh:
+			if p, ok := c.slaveConnPool[req.conn]; ok {
+				delete(c.slaveConnPool, req.conn)
+				if !c.isLiveSlavePool(req.name, p) {
+					// the replica was marked down (or otherwise dropped) and
+					// its pool replaced/cleared while this connection was
+					// checked out; p is orphaned, so just close the raw
+					// connection instead of handing it back to a pool
+					// nothing will ever Get it out of again.
+					req.conn.Close()
+				} else if req.discard {
+					p.Discard(req.conn)
+				} else {
+					p.Put(req.conn)
+				}
 			}
 
 		case err := <-c.alwaysErrCh:
 			c.alwaysErr = err
 
-		case sm := <-c.switchMasterCh:
-			if p, ok := c.masterPools[sm.name]; ok {
-				p.Empty()
-				p = pool.NewOrEmptyPool("tcp", sm.addr, c.poolSize)
-				c.masterPools[sm.name] = p
+		case r, ok := <-c.subReplyCh:
+			if !ok {
+				// the channel is only closed once its underlying Receive
+				// hits a non-timeout error, meaning the connection is dead;
+				// nil it out so this case blocks forever until reconnectedCh
+				// installs a fresh one, instead of spinning on the closed
+				// channel.
+				c.subReplyCh = nil
+				c.alwaysErr = &ClientError{err: errors.New("lost connection to sentinel, reconnecting"), SentinelErr: true}
+				go c.reconnectSpin(c.sentAddrs, c.names)
+				continue
+			}
+			if r.Timeout() || r.Err != nil {
+				continue
+			}
+			c.handleSubReply(r)
+
+		case sc := <-c.reconnectedCh:
+			// the old sentinel connections are done with; close them before
+			// they're overwritten so reconnecting doesn't leak an fd per
+			// lost sentinel.
+			c.cmdConn.Close()
+			c.subClient.Close()
+			c.cmdConn = sc.cmdConn
+			c.subClient = sc.subClient
+			c.subReplyCh = sc.subReplyCh
+			c.sentAddrs = sc.addrs
+			c.alwaysErr = nil
+			for name, addr := range sc.masters {
+				if addr == c.masterAddrs[name] {
+					continue
+				}
+				c.masterAddrs[name] = addr
+				if p, ok := c.masterPools[name]; ok {
+					p.Empty()
+					c.masterPools[name] = pool.NewOrEmptyPool("tcp", addr, c.poolSize)
+				}
+			}
+			c.installSlaves(sc.slaves)
+
+		case req := <-c.poolReqCh:
+			if c.alwaysErr != nil {
+				req.retCh <- &poolReqRet{nil, c.alwaysErr}
+				continue
+			}
+			p, ok := c.masterPools[req.name]
+			if !ok {
+				err := errors.New("unknown name: " + req.name)
+				req.retCh <- &poolReqRet{nil, &ClientError{err: err}}
+				continue
+			}
+			req.retCh <- &poolReqRet{p, nil}
+
+		case retCh := <-c.sentinelsReqCh:
+			addrs := make([]string, len(c.sentAddrs))
+			copy(addrs, c.sentAddrs)
+			retCh <- addrs
+
+		case <-sentinelsTick.C:
+			if c.alwaysErr != nil {
+				continue
+			}
+			for _, name := range c.names {
+				r := c.cmdConn.Cmd("SENTINEL", "SENTINELS", name)
+				if r.Type != redis.MultiReply {
+					continue
+				}
+				for _, e := range r.Elems {
+					l, err := e.List()
+					if err != nil {
+						continue
+					}
+					f := sentinelFields(l)
+					if f["ip"] == "" {
+						continue
+					}
+					c.mergeSentinelAddr(f["ip"] + ":" + f["port"])
+				}
 			}
 
 		case <-c.closeCh:
 			for name := range c.masterPools {
 				c.masterPools[name].Empty()
 			}
-			c.subClient.Client.Close()
-			close(c.getCh)
+			for name := range c.slavePools {
+				emptySlaveEntries(c.slavePools[name])
+			}
+			c.subClient.Close()
+			c.cmdConn.Close()
 			close(c.putCh)
+			close(c.putSlaveCh)
+			close(c.poolReqCh)
+			close(c.slavePoolReqCh)
+			close(c.masterConnRegCh)
+			close(c.slaveConnRegCh)
 			close(c.alwaysErrCh)
-			close(c.switchMasterCh)
 			return
 		}
 	}
 }
 
+// mergeSentinelAddr adds addr to the known set of sentinel addresses if it
+// isn't already present. It must only be called from spin.
+func (c *Client) mergeSentinelAddr(addr string) {
+	for _, a := range c.sentAddrs {
+		if a == addr {
+			return
+		}
+	}
+	c.sentAddrs = append(c.sentAddrs, addr)
+}
+
+// installSlaves replaces the tracked replicas of every master present in
+// slaves with freshly queried ones, closing the pools of all the replicas
+// previously tracked for that master and creating new pools for the given
+// ones. It must only be called from spin (or before spin is started).
+func (c *Client) installSlaves(slaves map[string][]slaveInfo) {
+	for name, infos := range slaves {
+		emptySlaveEntries(c.slavePools[name])
+
+		entries := make([]*slavePoolEntry, len(infos))
+		for i, si := range infos {
+			entry := &slavePoolEntry{addr: si.addr}
+			if !si.down {
+				entry.pool = pool.NewOrEmptyPool("tcp", si.addr, c.poolSize)
+			}
+			entries[i] = entry
+		}
+		c.slavePools[name] = entries
+	}
+}
+
+// emptySlaveEntries closes the pool backing every entry, if any.
+func emptySlaveEntries(entries []*slavePoolEntry) {
+	for _, e := range entries {
+		if e.pool != nil {
+			e.pool.Empty()
+		}
+	}
+}
+
+// applySlaveEvent updates the replica pools for a master in response to a
+// +slave/+sdown/+odown/-sdown/-odown pub/sub message. It must only be called
+// from spin.
+func (c *Client) applySlaveEvent(ev *slaveEvent) {
+	entries := c.slavePools[ev.masterName]
+
+	switch ev.kind {
+	case "+slave":
+		for _, e := range entries {
+			if e.addr == ev.addr {
+				return
+			}
+		}
+		entry := &slavePoolEntry{addr: ev.addr, pool: pool.NewOrEmptyPool("tcp", ev.addr, c.poolSize)}
+		c.slavePools[ev.masterName] = append(entries, entry)
+
+	case "+sdown", "+odown":
+		for _, e := range entries {
+			if e.addr == ev.addr && e.pool != nil {
+				e.pool.Empty()
+				e.pool = nil
+			}
+		}
+
+	case "-sdown", "-odown":
+		for _, e := range entries {
+			if e.addr == ev.addr && e.pool == nil {
+				e.pool = pool.NewOrEmptyPool("tcp", ev.addr, c.poolSize)
+			}
+		}
+	}
+}
+
+// selectSlavePool round-robins over the healthy (pool != nil) replicas known
+// for name, skipping down ones, and returns the first healthy one it finds.
+// It only picks a pool, it doesn't Get a connection from it, so it never
+// blocks. It must only be called from spin.
+func (c *Client) selectSlavePool(name string) (*pool.Pool, error) {
+	entries := c.slavePools[name]
+	if len(entries) == 0 {
+		return nil, errors.New("no known slaves for: " + name)
+	}
+
+	for i := 0; i < len(entries); i++ {
+		idx := c.slaveIdx[name] % len(entries)
+		c.slaveIdx[name]++
+		e := entries[idx]
+		if e.pool == nil {
+			continue
+		}
+		return e.pool, nil
+	}
+	return nil, errors.New("no healthy slaves for: " + name)
+}
+
+// isLiveSlavePool returns true if p is still the pool backing one of the
+// known replicas of name, as opposed to one that's since been evicted (on
+// +sdown/+odown or a resubscribe's installSlaves) and left orphaned. It must
+// only be called from spin.
+func (c *Client) isLiveSlavePool(name string, p *pool.Pool) bool {
+	for _, e := range c.slavePools[name] {
+		if e.pool == p {
+			return true
+		}
+	}
+	return false
+}
+
 // Retrieves a connection for the master of the given name. If sentinel has
 // become unreachable this will always return an error. Close should be called
-// in that case
-func (c *Client) GetMaster(name string) (*redis.Client, *ClientError) {
-	req := getReq{name, make(chan *getReqRet)}
-	c.getCh <- &req
-	ret := <-req.retCh
-	return ret.conn, ret.err
+// in that case, unless waiting is acceptable: the Client keeps retrying the
+// known sentinel addresses in the background, and GetMaster works again once
+// one of them answers. Get blocks until a connection is available or ctx is
+// done, so callers that don't want to bound how long they wait can pass
+// context.Background().
+//
+// Which pool to use is resolved via spin (as with masterPool), but the
+// blocking Get itself is done here, in the caller's own goroutine, so a pool
+// at its cap can't stall spin (and therefore every other Client method) while
+// it waits for a connection to be Put back. Once a connection is obtained,
+// the pool it came from is reported back to spin over masterConnRegCh so a
+// later PutMaster/DiscardMaster routes it back to that same pool even if a
+// +switch-master has since replaced masterPools[name] with a new one; if
+// that's happened the old pool is orphaned and the connection is simply
+// closed instead, since nothing will ever Get it back out of that pool again.
+func (c *Client) GetMaster(ctx context.Context, name string) (*redis.Client, *ClientError) {
+	p, cerr := c.masterPool(name)
+	if cerr != nil {
+		return nil, cerr
+	}
+	conn, err := p.Get(ctx)
+	if err != nil {
+		return nil, &ClientError{err: err}
+	}
+	c.masterConnRegCh <- &connReg{conn, p}
+	return conn, nil
 }
 
-// Return a connection for a master of a given name. As with the pool package,
-// do not return a connection which is having connectivity issues, or which is
-// otherwise unable to perform requests.
+// Return a connection for a master of a given name to the pool it came from.
+// As with the pool package, do not return a connection which is having
+// connectivity issues, or which is otherwise unable to perform requests; such
+// a connection should instead be passed to DiscardMaster.
 func (c *Client) PutMaster(name string, client *redis.Client) {
-	c.putCh <- &putReq{name, client}
+	c.putCh <- &putReq{name: name, conn: client}
+}
+
+// DiscardMaster closes a connection retrieved via GetMaster and frees its
+// slot in the pool it came from, instead of returning it for reuse. It should
+// be used in place of PutMaster whenever the connection has errored.
+func (c *Client) DiscardMaster(name string, client *redis.Client) {
+	c.putCh <- &putReq{name: name, conn: client, discard: true}
+}
+
+// CmdMaster is a convenience wrapper around GetMaster/PutMaster/DiscardMaster:
+// it acquires a connection to the master of the given name, runs cmd against
+// it, and returns it to the pool (or discards it, if the command errored)
+// before returning the reply. This is recommended over GetMaster/PutMaster
+// for the common case of running a single command, since forgetting to
+// PutMaster (leaking the connection) or PutMaster-ing a broken one (poisoning
+// the pool) is no longer possible.
+func (c *Client) CmdMaster(ctx context.Context, name string, cmd string, args ...interface{}) *redis.Reply {
+	conn, err := c.GetMaster(ctx, name)
+	if err != nil {
+		return &redis.Reply{Type: redis.ErrorReply, Err: err}
+	}
+	r := conn.Cmd(cmd, args...)
+	if r.Err != nil {
+		c.DiscardMaster(name, conn)
+	} else {
+		c.PutMaster(name, conn)
+	}
+	return r
+}
+
+// GetSlave retrieves a connection to one of the known healthy replicas of the
+// master with the given name, round-robining across them. If sentinel has
+// become unreachable, or there are no known healthy replicas, this returns an
+// error. Get blocks until a connection is available or ctx is done.
+//
+// As with GetMaster, spin only resolves which pool to use; the blocking Get
+// runs in the caller's own goroutine. Once a connection is obtained, the pool
+// it came from is reported back to spin over slaveConnRegCh so a later
+// PutSlave/DiscardSlave can find it again; if that replica's pool has since
+// been evicted (e.g. a +sdown) the old one is orphaned and the connection is
+// simply closed instead of being handed back to it.
+func (c *Client) GetSlave(ctx context.Context, name string) (*redis.Client, *ClientError) {
+	req := &poolReq{name, make(chan *poolReqRet)}
+	c.slavePoolReqCh <- req
+	ret := <-req.retCh
+	if ret.err != nil {
+		return nil, ret.err
+	}
+	conn, err := ret.pool.Get(ctx)
+	if err != nil {
+		return nil, &ClientError{err: err}
+	}
+	c.slaveConnRegCh <- &connReg{conn, ret.pool}
+	return conn, nil
+}
+
+// PutSlave returns a connection retrieved through GetSlave back to the pool
+// it came from. As with PutMaster, do not return a connection which is having
+// connectivity issues; pass it to DiscardSlave instead.
+func (c *Client) PutSlave(name string, client *redis.Client) {
+	c.putSlaveCh <- &putReq{name: name, conn: client}
+}
+
+// DiscardSlave closes a connection retrieved via GetSlave and frees its slot
+// in that replica's pool, instead of returning it for reuse.
+func (c *Client) DiscardSlave(name string, client *redis.Client) {
+	c.putSlaveCh <- &putReq{name: name, conn: client, discard: true}
+}
+
+// CmdSlave is the GetSlave/PutSlave/DiscardSlave analog of CmdMaster, running
+// cmd against one of the known healthy replicas of the given master.
+func (c *Client) CmdSlave(ctx context.Context, name string, cmd string, args ...interface{}) *redis.Reply {
+	conn, err := c.GetSlave(ctx, name)
+	if err != nil {
+		return &redis.Reply{Type: redis.ErrorReply, Err: err}
+	}
+	r := conn.Cmd(cmd, args...)
+	if r.Err != nil {
+		c.DiscardSlave(name, conn)
+	} else {
+		c.PutSlave(name, conn)
+	}
+	return r
+}
+
+// masterPool returns the current *pool.Pool backing the master of the given
+// name, for use by PipelineMaster/EvalMaster, which need direct access to run
+// more than one command against the same connection.
+func (c *Client) masterPool(name string) (*pool.Pool, *ClientError) {
+	req := &poolReq{name, make(chan *poolReqRet)}
+	c.poolReqCh <- req
+	ret := <-req.retCh
+	return ret.pool, ret.err
+}
+
+// PipelineMaster flushes pl against a connection to the master of the given
+// name, returning its replies in the order the commands were queued. This
+// gives failover-aware pipelining without the caller manually acquiring and
+// releasing a connection.
+func (c *Client) PipelineMaster(ctx context.Context, name string, pl *pipeline.Pipeline) ([]*redis.Reply, error) {
+	p, cerr := c.masterPool(name)
+	if cerr != nil {
+		return nil, cerr
+	}
+	return pl.Flush(ctx, p)
+}
+
+// EvalMaster runs script, a Lua script, against the master of the given name,
+// passing keys and args as KEYS and ARGV. The script's sha1 is cached (scoped
+// to this Client) across calls so repeated calls don't recompute it, and
+// EVALSHA/EVAL are used the same way pipeline.EvalScript.Run does, so a
+// SCRIPT FLUSH on the master doesn't require any action from the caller.
+func (c *Client) EvalMaster(ctx context.Context, name, script string, keys, args []interface{}) *redis.Reply {
+	p, cerr := c.masterPool(name)
+	if cerr != nil {
+		return &redis.Reply{Type: redis.ErrorReply, Err: cerr}
+	}
+	return c.evalScript(script).Run(ctx, p, keys, args)
+}
+
+func (c *Client) evalScript(script string) *pipeline.EvalScript {
+	c.evalMu.Lock()
+	defer c.evalMu.Unlock()
+	s, ok := c.evalScripts[script]
+	if !ok {
+		s = pipeline.NewEvalScript(script)
+		c.evalScripts[script] = s
+	}
+	return s
+}
+
+// Sentinels returns the set of sentinel addresses currently known to this
+// Client, with the one it's presently connected to first. This includes both
+// the addresses it was created with and any learned since via SENTINEL
+// SENTINELS.
+func (c *Client) Sentinels() []string {
+	retCh := make(chan []string)
+	c.sentinelsReqCh <- retCh
+	return <-retCh
 }
 
 // Closes all connection pools as well as the connection to sentinel.